@@ -0,0 +1,192 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command imagebuilder boots an instance from a source image, provisions
+// it, and snapshots the result into a new image, on either AWS or GCE.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/golang/glog"
+
+	"github.com/little170/kube-deploy/imagebuilder/pkg/imagebuilder"
+)
+
+var (
+	cloudProvider = flag.String("cloud", "aws", "cloud provider to build the image on: aws or gce")
+	name          = flag.String("name", "", "name to give the resulting image")
+	makePublic    = flag.Bool("public", false, "make the resulting image (and any replicas) publicly accessible")
+	replicate     = flag.String("replicate", "", "comma-separated list of projects (GCE) to replicate the image into; ignored for AWS, which replicates into every region")
+	sshPublicKey  = flag.String("ssh-public-key", "", "path to the SSH public key to install on the build instance")
+	sshPrivateKey = flag.String("ssh-private-key", "", "path to the SSH private key matching --ssh-public-key, used to provision the instance")
+	provision     = flag.String("provision-command", "", "shell command to run over SSH to provision the instance; repeat by separating with ';'")
+
+	awsRegion          = flag.String("aws-region", "", "AWS region")
+	awsImageID         = flag.String("aws-image-id", "", "AWS source AMI ID")
+	awsInstanceType    = flag.String("aws-instance-type", "", "AWS instance type")
+	awsSubnetID        = flag.String("aws-subnet-id", "", "AWS subnet ID")
+	awsSecurityGroupID = flag.String("aws-security-group-id", "", "AWS security group ID")
+
+	gceProject     = flag.String("gce-project", "", "GCE project")
+	gceZone        = flag.String("gce-zone", "", "GCE zone")
+	gceMachineType = flag.String("gce-machine-type", "", "GCE machine type")
+	gceSourceImage = flag.String("gce-source-image", "", "GCE source image")
+	gceNetwork     = flag.String("gce-network", "", "GCE network")
+	gceSubnetwork  = flag.String("gce-subnetwork", "", "GCE subnetwork")
+	gceImageFamily = flag.String("gce-image-family", "", "GCE image family to record on the resulting image")
+)
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		glog.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *name == "" {
+		return fmt.Errorf("--name must be specified")
+	}
+
+	cloud, err := buildCloud()
+	if err != nil {
+		return err
+	}
+
+	provisioner, err := buildProvisioner()
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("creating build instance")
+	instance, err := cloud.CreateInstance(provisioner)
+	if err != nil {
+		return fmt.Errorf("error creating build instance: %v", err)
+	}
+
+	glog.Infof("snapshotting instance into image %q", *name)
+	image, err := instance.Finalize(*name)
+	if err != nil {
+		return fmt.Errorf("error finalizing image: %v", err)
+	}
+
+	images, err := image.ReplicateImage(*makePublic)
+	if err != nil {
+		return fmt.Errorf("error replicating image: %v", err)
+	}
+
+	for target, img := range images {
+		glog.Infof("image available in %q: %s", target, img)
+	}
+
+	return nil
+}
+
+// buildCloud selects and constructs the Cloud implementation named by
+// --cloud, mirroring the same set of knobs across AWS and GCE.
+func buildCloud() (imagebuilder.Cloud, error) {
+	switch *cloudProvider {
+	case "aws":
+		config := &imagebuilder.AWSConfig{
+			Region:          *awsRegion,
+			ImageID:         *awsImageID,
+			InstanceType:    *awsInstanceType,
+			SubnetID:        *awsSubnetID,
+			SecurityGroupID: *awsSecurityGroupID,
+			SSHPublicKey:    *sshPublicKey,
+			Name:            *name,
+		}
+		return imagebuilder.NewAWSCloudFromConfig(config)
+
+	case "gce":
+		config := &imagebuilder.GCEConfig{
+			Project:            *gceProject,
+			Zone:               *gceZone,
+			MachineType:        *gceMachineType,
+			SourceImage:        *gceSourceImage,
+			Network:            *gceNetwork,
+			Subnetwork:         *gceSubnetwork,
+			SSHPublicKey:       *sshPublicKey,
+			ImageFamily:        *gceImageFamily,
+			ReplicationTargets: splitCSV(*replicate),
+		}
+		return imagebuilder.NewGCECloudFromConfig(config)
+
+	default:
+		return nil, fmt.Errorf("unknown --cloud %q (must be aws or gce)", *cloudProvider)
+	}
+}
+
+// buildProvisioner builds a ShellProvisioner from --provision-command, or
+// nil if no provisioning commands were given (e.g. the source image is
+// already fully baked).
+func buildProvisioner() (imagebuilder.Provisioner, error) {
+	if *provision == "" {
+		return nil, nil
+	}
+
+	if *sshPrivateKey == "" {
+		return nil, fmt.Errorf("--ssh-private-key must be specified to use --provision-command")
+	}
+
+	keyBytes, err := ioutil.ReadFile(*sshPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("error reading --ssh-private-key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --ssh-private-key: %v", err)
+	}
+
+	var commands []string
+	for _, command := range strings.Split(*provision, ";") {
+		if command = strings.TrimSpace(command); command != "" {
+			commands = append(commands, command)
+		}
+	}
+
+	return &imagebuilder.ShellProvisioner{
+		Commands: commands,
+		SSHConfig: &ssh.ClientConfig{
+			User:            "root",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		},
+	}, nil
+}
+
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -21,20 +21,33 @@ limitations under the License.
 package imagebuilder
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/hex"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 )
 
+// defaultInstanceRunningTimeout bounds how long we wait for an instance to
+// report running+reachable state before we give up on DialSSH.
+const defaultInstanceRunningTimeout = 5 * time.Minute
+
+// defaultImageAvailableTimeout bounds how long we wait for an AMI to reach
+// the "available" state.
+const defaultImageAvailableTimeout = 15 * time.Minute
+
 const tagRoleKey = "k8s.io/role/imagebuilder"
 
 // AWSInstance manages an AWS instance, used for building an image
@@ -44,36 +57,88 @@ type AWSInstance struct {
 	instance   *ec2.Instance
 }
 
-// Shutdown terminates the running instance
-func (i *AWSInstance) Shutdown() error {
+// Address returns the instance's public IP, once it has one
+func (i *AWSInstance) Address() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultInstanceRunningTimeout)
+	defer cancel()
+	return i.WaitPublicIP(ctx)
+}
+
+// Finalize creates an AMI named name from the instance, waits for it to
+// become available, and then terminates the instance.
+func (i *AWSInstance) Finalize(name string) (Image, error) {
+	request := &ec2.CreateImageInput{
+		InstanceId: aws.String(i.instanceID),
+		Name:       aws.String(name),
+		NoReboot:   aws.Bool(true),
+	}
+
+	glog.V(2).Infof("AWS CreateImage InstanceId=%q Name=%q", i.instanceID, name)
+	response, err := i.cloud.ec2.CreateImage(request)
+	if err != nil {
+		return nil, fmt.Errorf("error making AWS CreateImage call: %v", err)
+	}
+
+	imageID := aws.StringValue(response.ImageId)
+	image := &AWSImage{
+		ec2:     i.cloud.ec2,
+		region:  i.cloud.config.Region,
+		imageID: imageID,
+		config:  i.cloud.config,
+	}
+
+	tags := i.cloud.buildResourceTags(&ec2.Tag{
+		Key: aws.String("SourceImageID"), Value: aws.String(i.cloud.config.ImageID),
+	})
+	if err := i.cloud.TagResource(imageID, tags...); err != nil {
+		glog.Warningf("error tagging AMI %q: %v", imageID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultImageAvailableTimeout)
+	defer cancel()
+	if err := image.waitStatusAvailable(ctx); err != nil {
+		return nil, fmt.Errorf("error waiting for image %q to become available: %v", imageID, err)
+	}
+
+	img, err := describeAWSImage(i.cloud.ec2, imageID)
+	if err != nil {
+		return nil, err
+	}
+	image.image = img
+
 	glog.Infof("Terminating instance %q", i.instanceID)
-	return i.cloud.TerminateInstance(i.instanceID)
+	if err := i.cloud.TerminateInstance(i.instanceID); err != nil {
+		return nil, fmt.Errorf("error terminating instance %q after snapshot: %v", i.instanceID, err)
+	}
+
+	return image, nil
 }
 
 // DialSSH establishes an SSH client connection to the instance
 func (i *AWSInstance) DialSSH(config *ssh.ClientConfig) (*ssh.Client, error) {
-	publicIP, err := i.WaitPublicIP()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultInstanceRunningTimeout)
+	defer cancel()
+
+	if err := i.cloud.waitInstanceRunning(ctx, i.instanceID); err != nil {
+		return nil, fmt.Errorf("error waiting for instance %q to be running: %v", i.instanceID, err)
+	}
+
+	publicIP, err := i.WaitPublicIP(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	for {
-		// TODO: Timeout, check error code
-		sshClient, err := ssh.Dial("tcp", publicIP+":22", config)
-		if err != nil {
-			glog.Warningf("error connecting to SSH on server %q: %v", publicIP, err)
-			time.Sleep(5 * time.Second)
-			continue
-			//	return nil, fmt.Errorf("error connecting to SSH on server %q", publicIP)
-		}
-
-		return sshClient, nil
+	addr := publicIP + ":22"
+	if err := waitTCPPortOpen(ctx, addr); err != nil {
+		return nil, err
 	}
+
+	return ssh.Dial("tcp", addr, config)
 }
 
-// WaitPublicIP waits for the instance to get a public IP, returning it
-func (i *AWSInstance) WaitPublicIP() (string, error) {
-	// TODO: Timeout
+// WaitPublicIP waits for the instance to get a public IP, returning it, and
+// gives up once ctx is done.
+func (i *AWSInstance) WaitPublicIP(ctx context.Context) (string, error) {
 	for {
 		instance, err := i.cloud.describeInstance(i.instanceID)
 		if err != nil {
@@ -85,7 +150,11 @@ func (i *AWSInstance) WaitPublicIP() (string, error) {
 			return publicIP, nil
 		}
 		glog.V(2).Infof("Sleeping before requerying instance for public IP: %q", i.instanceID)
-		time.Sleep(5 * time.Second)
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("timed out waiting for instance %q to get a public IP: %v", i.instanceID, ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
 	}
 }
 
@@ -105,20 +174,57 @@ func NewAWSCloud(ec2 *ec2.EC2, config *AWSConfig) *AWSCloud {
 	}
 }
 
+// NewAWSCloudFromConfig builds an AWSCloud, resolving credentials itself
+// rather than requiring the caller to build an *ec2.EC2 client. If
+// config.AssumeRoleARN is set, it assumes that role via STS; otherwise it
+// falls back to the standard AWS credential chain, and finally to the EC2
+// instance profile if no static/environment credentials are configured.
+func NewAWSCloudFromConfig(config *AWSConfig) (*AWSCloud, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(config.Region)})
+	if err != nil {
+		return nil, fmt.Errorf("error building AWS session: %v", err)
+	}
+
+	creds := sess.Config.Credentials
+	if config.AssumeRoleARN != "" {
+		creds = stscreds.NewCredentials(sess, config.AssumeRoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if config.ExternalID != "" {
+				p.ExternalID = aws.String(config.ExternalID)
+			}
+			if config.SessionName != "" {
+				p.RoleSessionName = config.SessionName
+			}
+		})
+	} else if _, err := creds.Get(); err != nil {
+		glog.V(2).Infof("no static/environment AWS credentials found, falling back to EC2 instance profile: %v", err)
+		creds = ec2rolecreds.NewCredentialsWithClient(ec2metadata.New(sess))
+	}
+
+	ec2Client := ec2.New(sess, &aws.Config{Credentials: creds})
+
+	return NewAWSCloud(ec2Client, config), nil
+}
+
 func (a *AWSCloud) GetExtraEnv() (map[string]string, error) {
-	credentials := a.ec2.Config.Credentials
-	if credentials == nil {
+	creds := a.ec2.Config.Credentials
+	if creds == nil {
 		return nil, fmt.Errorf("unable to determine EC2 credentials")
 	}
 
-	creds, err := credentials.Get()
+	// Credentials.Get refreshes expired/expiring credentials (e.g. from an
+	// assumed role or the instance profile) before returning them, so long
+	// builds don't die partway through on an expired session token.
+	value, err := creds.Get()
 	if err != nil {
 		return nil, fmt.Errorf("error fetching EC2 credentials: %v", err)
 	}
 
 	env := make(map[string]string)
-	env["AWS_ACCESS_KEY"] = creds.AccessKeyID
-	env["AWS_SECRET_KEY"] = creds.SecretAccessKey
+	env["AWS_ACCESS_KEY"] = value.AccessKeyID
+	env["AWS_SECRET_KEY"] = value.SecretAccessKey
+	if value.SessionToken != "" {
+		env["AWS_SESSION_TOKEN"] = value.SessionToken
+	}
 
 	return env, nil
 }
@@ -145,6 +251,55 @@ func (a *AWSCloud) describeInstance(instanceID string) (*ec2.Instance, error) {
 	return nil, nil
 }
 
+// waitInstanceRunning polls DescribeInstanceStatus until the instance
+// reports state "running" with both system and instance reachability
+// checks passed, or ctx is cancelled.
+func (a *AWSCloud) waitInstanceRunning(ctx context.Context, instanceID string) error {
+	delay := 2 * time.Second
+	const maxDelay = 30 * time.Second
+
+	for {
+		request := &ec2.DescribeInstanceStatusInput{}
+		request.InstanceIds = []*string{&instanceID}
+
+		glog.V(2).Infof("AWS DescribeInstanceStatus InstanceId=%q", instanceID)
+		var response *ec2.DescribeInstanceStatusOutput
+		err := retryOnThrottle(ctx, func() error {
+			var err error
+			response, err = a.ec2.DescribeInstanceStatus(request)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error making AWS DescribeInstanceStatus call: %v", err)
+		}
+
+		if len(response.InstanceStatuses) == 1 {
+			status := response.InstanceStatuses[0]
+			state := aws.StringValue(status.InstanceState.Name)
+			instanceStatus := aws.StringValue(status.InstanceStatus.Status)
+			systemStatus := aws.StringValue(status.SystemStatus.Status)
+
+			if state == "running" && instanceStatus == "ok" && systemStatus == "ok" {
+				return nil
+			}
+			glog.V(2).Infof("instance %q not yet ready (state=%q, instanceStatus=%q, systemStatus=%q); waiting", instanceID, state, instanceStatus, systemStatus)
+		} else {
+			glog.V(2).Infof("instance %q has no status yet; waiting", instanceID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for instance %q to become running", instanceID)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
 // TerminateInstance terminates the specified instance
 func (a *AWSCloud) TerminateInstance(instanceID string) error {
 	request := &ec2.TerminateInstancesInput{}
@@ -155,17 +310,36 @@ func (a *AWSCloud) TerminateInstance(instanceID string) error {
 	return err
 }
 
+// discoveryFilters builds the EC2 filters used to find resources belonging
+// to this builder, requiring each of the given tags to be present with the
+// given value. If tags is empty, it falls back to requiring tagRoleKey to
+// be present with any value, matching the previous tag-key-only behavior.
+func discoveryFilters(tags map[string]string) []*ec2.Filter {
+	if len(tags) == 0 {
+		return []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: aws.StringSlice([]string{tagRoleKey}),
+			},
+		}
+	}
+
+	var filters []*ec2.Filter
+	for k, v := range tags {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("tag:" + k),
+			Values: aws.StringSlice([]string{v}),
+		})
+	}
+	return filters
+}
+
 // GetInstance returns the AWS instance matching our tags, or nil if not found
 func (a *AWSCloud) GetInstance() (Instance, error) {
 	request := &ec2.DescribeInstancesInput{}
-	request.Filters = []*ec2.Filter{
-		{
-			Name:   aws.String("tag-key"),
-			Values: aws.StringSlice([]string{tagRoleKey}),
-		},
-	}
+	request.Filters = discoveryFilters(a.config.DiscoveryTags)
 
-	glog.V(2).Infof("AWS DescribeInstances Filter:tag-key=%s", tagRoleKey)
+	glog.V(2).Infof("AWS DescribeInstances Filter:%v", a.config.DiscoveryTags)
 	response, err := a.ec2.DescribeInstances(request)
 	if err != nil {
 		return nil, fmt.Errorf("error making AWS DescribeInstances call: %v", err)
@@ -190,17 +364,12 @@ func (a *AWSCloud) GetInstance() (Instance, error) {
 	return nil, nil
 }
 
-// findSubnet returns a subnet tagged with our role tag, if one exists
+// findSubnet returns a subnet tagged with our discovery tags, if one exists
 func (c *AWSCloud) findSubnet() (*ec2.Subnet, error) {
 	request := &ec2.DescribeSubnetsInput{}
-	request.Filters = []*ec2.Filter{
-		{
-			Name:   aws.String("tag-key"),
-			Values: aws.StringSlice([]string{tagRoleKey}),
-		},
-	}
+	request.Filters = discoveryFilters(c.config.DiscoveryTags)
 
-	glog.V(2).Infof("AWS DescribeSubnets Filter:tag-key=%s", tagRoleKey)
+	glog.V(2).Infof("AWS DescribeSubnets Filter:%v", c.config.DiscoveryTags)
 	response, err := c.ec2.DescribeSubnets(request)
 	if err != nil {
 		return nil, fmt.Errorf("error making AWS DescribeSubnets call: %v", err)
@@ -213,21 +382,16 @@ func (c *AWSCloud) findSubnet() (*ec2.Subnet, error) {
 	return nil, nil
 }
 
-// findSecurityGroup returns a security group tagged with our role tag, if one exists
+// findSecurityGroup returns a security group tagged with our discovery
+// tags in the given VPC, if one exists
 func (c *AWSCloud) findSecurityGroup(vpcID string) (*ec2.SecurityGroup, error) {
 	request := &ec2.DescribeSecurityGroupsInput{}
-	request.Filters = []*ec2.Filter{
-		{
-			Name:   aws.String("tag-key"),
-			Values: aws.StringSlice([]string{tagRoleKey}),
-		},
-		{
-			Name:   aws.String("vpc-id"),
-			Values: aws.StringSlice([]string{vpcID}),
-		},
-	}
+	request.Filters = append(discoveryFilters(c.config.DiscoveryTags), &ec2.Filter{
+		Name:   aws.String("vpc-id"),
+		Values: aws.StringSlice([]string{vpcID}),
+	})
 
-	glog.V(2).Infof("AWS DescribeSecurityGroups Filter:tag-key=%s", tagRoleKey)
+	glog.V(2).Infof("AWS DescribeSecurityGroups Filter:%v, vpc-id=%s", c.config.DiscoveryTags, vpcID)
 	response, err := c.ec2.DescribeSecurityGroups(request)
 	if err != nil {
 		return nil, fmt.Errorf("error making AWS DescribeSecurityGroups call: %v", err)
@@ -273,6 +437,40 @@ func (a *AWSCloud) TagResource(resourceId string, tags ...*ec2.Tag) error {
 	return err
 }
 
+// buildResourceTags returns the tags we apply to every resource we create,
+// so they are discoverable (and garbage-collectable) later: the role tag
+// (valued with BuildID, so it's actually useful instead of a placeholder),
+// Name, BuildID, DiscoveryTags (so discoveryFilters can find resources we
+// just created), and, if ClusterName is set, the same
+// kubernetes.io/cluster/<name>=owned tag kops uses.
+func (c *AWSCloud) buildResourceTags(extra ...*ec2.Tag) []*ec2.Tag {
+	roleValue := c.config.BuildID
+	if roleValue == "" {
+		roleValue = c.config.Name
+	}
+
+	tags := []*ec2.Tag{
+		{Key: aws.String(tagRoleKey), Value: aws.String(roleValue)},
+	}
+	if c.config.Name != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String("Name"), Value: aws.String(c.config.Name)})
+	}
+	if c.config.BuildID != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String("BuildID"), Value: aws.String(c.config.BuildID)})
+	}
+	for k, v := range c.config.DiscoveryTags {
+		tags = append(tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	if c.config.ClusterName != "" {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String("kubernetes.io/cluster/" + c.config.ClusterName),
+			Value: aws.String("owned"),
+		})
+	}
+
+	return append(tags, extra...)
+}
+
 func (c *AWSCloud) findSSHKey(name string) (*ec2.KeyPairInfo, error) {
 	request := &ec2.DescribeKeyPairsInput{
 		KeyNames: []*string{&name},
@@ -332,17 +530,96 @@ func (c *AWSCloud) ensureSSHKey() (string, error) {
 		return "", fmt.Errorf("error creating AWS KeyPair: %v", err)
 	}
 
+	keyPairID := aws.StringValue(response.KeyPairId)
+	if keyPairID == "" {
+		// Older API responses don't return a KeyPairId; fall back to
+		// tagging by name, which CreateTags also accepts for key pairs.
+		keyPairID = name
+	}
+	if err := c.TagResource(keyPairID, c.buildResourceTags()...); err != nil {
+		glog.Warningf("error tagging AWS KeyPair %q: %v", keyPairID, err)
+	}
+
 	return *response.KeyName, nil
 }
 
+// rootVolumeBlockDeviceMapping builds a BlockDeviceMapping overriding the
+// root volume's size/type/IOPS/encryption, or nil if the config doesn't
+// customize any of them (in which case the source image's defaults apply).
+func (c *AWSCloud) rootVolumeBlockDeviceMapping() (*ec2.BlockDeviceMapping, error) {
+	cfg := c.config
+	if cfg.RootVolumeSizeGB == 0 && cfg.RootVolumeType == "" && cfg.RootVolumeIOPS == 0 && !cfg.Encrypted && cfg.KMSKeyID == "" {
+		return nil, nil
+	}
+
+	rootDeviceName, err := c.findRootDeviceName(cfg.ImageID)
+	if err != nil {
+		return nil, err
+	}
+
+	ebs := &ec2.EbsBlockDevice{
+		DeleteOnTermination: aws.Bool(true),
+	}
+	if cfg.RootVolumeSizeGB != 0 {
+		ebs.VolumeSize = aws.Int64(cfg.RootVolumeSizeGB)
+	}
+	if cfg.RootVolumeType != "" {
+		ebs.VolumeType = aws.String(cfg.RootVolumeType)
+	}
+	if cfg.RootVolumeIOPS != 0 {
+		ebs.Iops = aws.Int64(cfg.RootVolumeIOPS)
+	}
+	if cfg.Encrypted {
+		ebs.Encrypted = aws.Bool(true)
+	}
+	if cfg.KMSKeyID != "" {
+		ebs.KmsKeyId = aws.String(cfg.KMSKeyID)
+	}
+
+	return &ec2.BlockDeviceMapping{
+		DeviceName: aws.String(rootDeviceName),
+		Ebs:        ebs,
+	}, nil
+}
+
+// findRootDeviceName looks up the root device name (e.g. /dev/xvda or
+// /dev/sda1) that the given AMI actually boots from, so that our override
+// mapping replaces the root volume instead of attaching an extra one.
+func (c *AWSCloud) findRootDeviceName(imageID string) (string, error) {
+	request := &ec2.DescribeImagesInput{}
+	request.ImageIds = []*string{aws.String(imageID)}
+
+	glog.V(2).Infof("AWS DescribeImages ImageId=%q", imageID)
+	response, err := c.ec2.DescribeImages(request)
+	if err != nil {
+		return "", fmt.Errorf("error making AWS DescribeImages call: %v", err)
+	}
+
+	if len(response.Images) != 1 {
+		return "", fmt.Errorf("could not find AMI %q", imageID)
+	}
+
+	rootDeviceName := aws.StringValue(response.Images[0].RootDeviceName)
+	if rootDeviceName == "" {
+		return "", fmt.Errorf("AMI %q does not report a root device name", imageID)
+	}
+
+	return rootDeviceName, nil
+}
+
 // CreateInstance creates an instance for building an image instance
-func (c *AWSCloud) CreateInstance() (Instance, error) {
+func (c *AWSCloud) CreateInstance(provisioner Provisioner) (Instance, error) {
+	needsSSH := provisioner == nil || provisioner.NeedsSSH()
+
 	var err error
-	sshKeyName := c.config.SSHKeyName
-	if sshKeyName == "" {
-		sshKeyName, err = c.ensureSSHKey()
-		if err != nil {
-			return nil, err
+	var sshKeyName string
+	if needsSSH {
+		sshKeyName = c.config.SSHKeyName
+		if sshKeyName == "" {
+			sshKeyName, err = c.ensureSSHKey()
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -393,12 +670,14 @@ func (c *AWSCloud) CreateInstance() (Instance, error) {
 
 	request := &ec2.RunInstancesInput{}
 	request.ImageId = aws.String(c.config.ImageID)
-	request.KeyName = aws.String(sshKeyName)
+	if needsSSH {
+		request.KeyName = aws.String(sshKeyName)
+	}
 	request.InstanceType = aws.String(c.config.InstanceType)
 	request.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{
 		{
 			DeviceIndex:              aws.Int64(0),
-			AssociatePublicIpAddress: aws.Bool(true),
+			AssociatePublicIpAddress: aws.Bool(needsSSH),
 			SubnetId:                 aws.String(subnetID),
 			Groups:                   aws.StringSlice([]string{securityGroupID}),
 		},
@@ -406,6 +685,24 @@ func (c *AWSCloud) CreateInstance() (Instance, error) {
 	request.MaxCount = aws.Int64(1)
 	request.MinCount = aws.Int64(1)
 
+	blockDeviceMapping, err := c.rootVolumeBlockDeviceMapping()
+	if err != nil {
+		return nil, err
+	}
+	if blockDeviceMapping != nil {
+		request.BlockDeviceMappings = []*ec2.BlockDeviceMapping{blockDeviceMapping}
+	}
+
+	if provisioner != nil {
+		userData, err := provisioner.UserData()
+		if err != nil {
+			return nil, fmt.Errorf("error building user-data: %v", err)
+		}
+		if len(userData) != 0 {
+			request.UserData = aws.String(base64.StdEncoding.EncodeToString(userData))
+		}
+	}
+
 	glog.V(2).Infof("AWS RunInstances InstanceType=%q ImageId=%q KeyName=%q", c.config.InstanceType, c.config.ImageID, sshKeyName)
 	response, err := c.ec2.RunInstances(request)
 	if err != nil {
@@ -417,9 +714,10 @@ func (c *AWSCloud) CreateInstance() (Instance, error) {
 		if instanceID == "" {
 			return nil, fmt.Errorf("AWS RunInstances call returned empty InstanceId")
 		}
-		err := c.TagResource(instanceID, &ec2.Tag{
-			Key: aws.String(tagRoleKey), Value: aws.String("'"),
+		tags := c.buildResourceTags(&ec2.Tag{
+			Key: aws.String("SourceImageID"), Value: aws.String(c.config.ImageID),
 		})
+		err := c.TagResource(instanceID, tags...)
 		if err != nil {
 			glog.Warningf("Tagging instance %q failed; will terminate to prevent leaking", instanceID)
 			e2 := c.TerminateInstance(instanceID)
@@ -429,11 +727,19 @@ func (c *AWSCloud) CreateInstance() (Instance, error) {
 			return nil, err
 		}
 
-		return &AWSInstance{
+		created := &AWSInstance{
 			cloud:      c,
 			instance:   instance,
 			instanceID: instanceID,
-		}, nil
+		}
+
+		if provisioner != nil {
+			if err := provisioner.Provision(created); err != nil {
+				return nil, fmt.Errorf("error provisioning instance %q: %v", instanceID, err)
+			}
+		}
+
+		return created, nil
 	}
 	return nil, fmt.Errorf("instance was not returned by AWS RunInstances")
 }
@@ -459,6 +765,7 @@ func (a *AWSCloud) FindImage(imageName string) (Image, error) {
 		region:  a.config.Region,
 		image:   image,
 		imageID: imageID,
+		config:  a.config,
 	}, nil
 }
 
@@ -491,6 +798,24 @@ func findAWSImage(client *ec2.EC2, imageName string) (*ec2.Image, error) {
 	return image, nil
 }
 
+// describeAWSImage looks up an image by ID
+func describeAWSImage(client *ec2.EC2, imageID string) (*ec2.Image, error) {
+	request := &ec2.DescribeImagesInput{}
+	request.ImageIds = aws.StringSlice([]string{imageID})
+
+	glog.V(2).Infof("AWS DescribeImages ImageId=%q", imageID)
+	response, err := client.DescribeImages(request)
+	if err != nil {
+		return nil, fmt.Errorf("error making AWS DescribeImages call: %v", err)
+	}
+
+	if len(response.Images) == 0 {
+		return nil, fmt.Errorf("image not found %q", imageID)
+	}
+
+	return response.Images[0], nil
+}
+
 // AWSImage represents an AMI on AWS
 type AWSImage struct {
 	ec2    *ec2.EC2
@@ -498,6 +823,11 @@ type AWSImage struct {
 	//cloud   *AWSCloud
 	image   *ec2.Image
 	imageID string
+
+	// config is the AWSConfig of the cloud that produced this image, used
+	// e.g. to pick the right KMS key when replicating to other regions.
+	// It may be nil for images constructed directly from an imageID.
+	config *AWSConfig
 }
 
 // ID returns the AWS identifier for the image
@@ -515,16 +845,25 @@ func (i *AWSImage) EnsurePublic() error {
 	return i.ensurePublic()
 }
 
-func (i *AWSImage) waitStatusAvailable() error {
+// waitStatusAvailable polls DescribeImages until the image reaches
+// "available", fails fast on a terminal error state, or ctx is cancelled.
+func (i *AWSImage) waitStatusAvailable(ctx context.Context) error {
 	imageID := i.imageID
 
+	delay := 5 * time.Second
+	const maxDelay = 30 * time.Second
+
 	for {
-		// TODO: Timeout
 		request := &ec2.DescribeImagesInput{}
 		request.ImageIds = aws.StringSlice([]string{imageID})
 
 		glog.V(2).Infof("AWS DescribeImages ImageId=%q", imageID)
-		response, err := i.ec2.DescribeImages(request)
+		var response *ec2.DescribeImagesOutput
+		err := retryOnThrottle(ctx, func() error {
+			var err error
+			response, err = i.ec2.DescribeImages(request)
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("error making AWS DescribeImages call: %v", err)
 		}
@@ -541,16 +880,36 @@ func (i *AWSImage) waitStatusAvailable() error {
 
 		state := aws.StringValue(image.State)
 		glog.V(2).Infof("image state %q", state)
-		if state == "available" {
+		switch state {
+		case "available":
 			return nil
+		case "failed", "invalid", "deregistered", "error":
+			reason := ""
+			if image.StateReason != nil {
+				reason = aws.StringValue(image.StateReason.Message)
+			}
+			return fmt.Errorf("image %q entered terminal state %q: %s", imageID, state, reason)
 		}
+
 		glog.Infof("Image not yet available (%s); waiting", imageID)
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for image %q to become available (last state %q)", imageID, state)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
 	}
 }
 
 func (i *AWSImage) ensurePublic() error {
-	err := i.waitStatusAvailable()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultImageAvailableTimeout)
+	defer cancel()
+
+	err := i.waitStatusAvailable(ctx)
 	if err != nil {
 		return err
 	}
@@ -577,9 +936,17 @@ func (i *AWSImage) ensurePublic() error {
 func (i *AWSImage) ReplicateImage(makePublic bool) (map[string]Image, error) {
 	imagesByRegion := make(map[string]Image)
 
+	ctx, cancel := context.WithTimeout(context.Background(), defaultImageAvailableTimeout)
+	defer cancel()
+
 	glog.V(2).Infof("AWS DescribeRegions")
 	request := &ec2.DescribeRegionsInput{}
-	response, err := i.ec2.DescribeRegions(request)
+	var response *ec2.DescribeRegionsOutput
+	err := retryOnThrottle(ctx, func() error {
+		var err error
+		response, err = i.ec2.DescribeRegions(request)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error listing ec2 regions: %v", err)
 
@@ -592,16 +959,32 @@ func (i *AWSImage) ReplicateImage(makePublic bool) (map[string]Image, error) {
 			continue
 		}
 
-		imageID, err := i.copyImageToRegion(regionName)
+		// Each region's copy gets its own defaultImageAvailableTimeout, rather
+		// than all regions sharing one.
+		regionCtx, regionCancel := context.WithTimeout(context.Background(), defaultImageAvailableTimeout)
+		imageID, err := i.copyImageToRegion(regionCtx, regionName)
 		if err != nil {
+			regionCancel()
 			return nil, fmt.Errorf("error copying image to region %q: %v", regionName, err)
 		}
 		targetEC2 := ec2.New(session.New(), &aws.Config{Region: &regionName})
-		imagesByRegion[regionName] = &AWSImage{
+		targetImage := &AWSImage{
 			ec2:     targetEC2,
 			region:  regionName,
 			imageID: imageID,
+			config:  i.config,
 		}
+
+		// Don't hand back a copy until it is actually usable: a reader
+		// could otherwise try to boot it (or make it public) while it is
+		// still "pending" in the destination region.
+		err = targetImage.waitStatusAvailable(regionCtx)
+		regionCancel()
+		if err != nil {
+			return nil, fmt.Errorf("error waiting for copied image to become available in region %q: %v", regionName, err)
+		}
+
+		imagesByRegion[regionName] = targetImage
 	}
 
 	if makePublic {
@@ -616,7 +999,7 @@ func (i *AWSImage) ReplicateImage(makePublic bool) (map[string]Image, error) {
 	return imagesByRegion, nil
 }
 
-func (i *AWSImage) copyImageToRegion(regionName string) (string, error) {
+func (i *AWSImage) copyImageToRegion(ctx context.Context, regionName string) (string, error) {
 	targetEC2 := ec2.New(session.New(), &aws.Config{Region: &regionName})
 
 	imageName := aws.StringValue(i.image.Name)
@@ -642,8 +1025,19 @@ func (i *AWSImage) copyImageToRegion(regionName string) (string, error) {
 			SourceImageId: aws.String(i.imageID),
 			SourceRegion:  aws.String(i.region),
 		}
+		if i.config != nil && i.config.Encrypted {
+			request.Encrypted = aws.Bool(true)
+			if kmsKeyID := i.config.ReplicationKMSKeyIDs[regionName]; kmsKeyID != "" {
+				request.KmsKeyId = aws.String(kmsKeyID)
+			}
+		}
 		glog.V(2).Infof("AWS CopyImage Image=%q, Region=%q", i.imageID, regionName)
-		response, err := targetEC2.CopyImage(request)
+		var response *ec2.CopyImageOutput
+		err := retryOnThrottle(ctx, func() error {
+			var err error
+			response, err = targetEC2.CopyImage(request)
+			return err
+		})
 		if err != nil {
 			return "", fmt.Errorf("error copying image to region %q: %v", regionName, err)
 		}
@@ -0,0 +1,123 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func filterStrings(filters []*ec2.Filter) []string {
+	var out []string
+	for _, f := range filters {
+		for _, v := range f.Values {
+			out = append(out, aws.StringValue(f.Name)+"="+aws.StringValue(v))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestDiscoveryFiltersFallsBackToTagRoleKey(t *testing.T) {
+	filters := discoveryFilters(nil)
+	if len(filters) != 1 {
+		t.Fatalf("expected a single fallback filter, got %v", filters)
+	}
+	if aws.StringValue(filters[0].Name) != "tag-key" {
+		t.Errorf("expected fallback filter on tag-key, got %q", aws.StringValue(filters[0].Name))
+	}
+	if got := aws.StringValueSlice(filters[0].Values); len(got) != 1 || got[0] != tagRoleKey {
+		t.Errorf("expected fallback filter value %q, got %v", tagRoleKey, got)
+	}
+}
+
+func TestDiscoveryFiltersUsesConfiguredTags(t *testing.T) {
+	tags := map[string]string{"Name": "my-builder", "Team": "k8s"}
+	got := filterStrings(discoveryFilters(tags))
+	want := []string{"tag:Name=my-builder", "tag:Team=k8s"}
+	sort.Strings(want)
+
+	if len(got) != len(want) {
+		t.Fatalf("discoveryFilters(%v) = %v, want %v", tags, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("discoveryFilters(%v) = %v, want %v", tags, got, want)
+		}
+	}
+}
+
+func TestBuildResourceTagsIncludesDiscoveryTags(t *testing.T) {
+	c := &AWSCloud{config: &AWSConfig{
+		Name:          "my-builder",
+		BuildID:       "build-123",
+		ClusterName:   "my-cluster",
+		DiscoveryTags: map[string]string{"Team": "k8s"},
+	}}
+
+	tags := c.buildResourceTags()
+
+	byKey := map[string]string{}
+	for _, tag := range tags {
+		byKey[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	if byKey[tagRoleKey] != "build-123" {
+		t.Errorf("expected role tag value %q, got %q", "build-123", byKey[tagRoleKey])
+	}
+	if byKey["Name"] != "my-builder" {
+		t.Errorf("expected Name tag %q, got %q", "my-builder", byKey["Name"])
+	}
+	if byKey["BuildID"] != "build-123" {
+		t.Errorf("expected BuildID tag %q, got %q", "build-123", byKey["BuildID"])
+	}
+	if byKey["Team"] != "k8s" {
+		t.Errorf("expected DiscoveryTags to be applied as a literal tag, got %v", byKey)
+	}
+	if byKey["kubernetes.io/cluster/my-cluster"] != "owned" {
+		t.Errorf("expected cluster tag, got %v", byKey)
+	}
+
+	// A resource created with these tags must also satisfy the filters
+	// discoveryFilters builds for the same DiscoveryTags, or GetInstance
+	// would never find what we just created.
+	for _, filter := range discoveryFilters(c.config.DiscoveryTags) {
+		key := aws.StringValue(filter.Name)[len("tag:"):]
+		wantValue := aws.StringValueSlice(filter.Values)[0]
+		if byKey[key] != wantValue {
+			t.Errorf("created resource tag %q=%q does not satisfy discovery filter %q=%q", key, byKey[key], key, wantValue)
+		}
+	}
+}
+
+func TestBuildResourceTagsFallsBackToNameWhenNoBuildID(t *testing.T) {
+	c := &AWSCloud{config: &AWSConfig{Name: "my-builder"}}
+
+	tags := c.buildResourceTags()
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == tagRoleKey {
+			if aws.StringValue(tag.Value) != "my-builder" {
+				t.Errorf("expected role tag to fall back to Name, got %q", aws.StringValue(tag.Value))
+			}
+			return
+		}
+	}
+	t.Fatalf("role tag %q not found in %v", tagRoleKey, tags)
+}
@@ -4,7 +4,7 @@ import "golang.org/x/crypto/ssh"
 
 type Cloud interface {
 	GetInstance() (Instance, error)
-	CreateInstance() (Instance, error)
+	CreateInstance(provisioner Provisioner) (Instance, error)
 
 	FindImage(imageName string) (Image, error)
 
@@ -13,10 +13,29 @@ type Cloud interface {
 
 type Instance interface {
 	DialSSH(config *ssh.ClientConfig) (*ssh.Client, error)
-	Shutdown() error
+
+	// Address returns the instance's externally-reachable address, once
+	// it has one.
+	Address() (string, error)
+
+	// Finalize snapshots the instance into a new image named name, then
+	// terminates the instance.
+	Finalize(name string) (Image, error)
 }
 
 type Image interface {
 	EnsurePublic() error
 	ReplicateImage(makePublic bool) (map[string]Image, error)
+
+	// ExportToS3 exports the image as a VM disk image (e.g. VMDK or RAW)
+	// into an S3 bucket/prefix, for consumption by hypervisors other
+	// than the one that built it. Backends that can't support this
+	// return a descriptive error rather than silently doing nothing.
+	ExportToS3(bucket, prefix, format string) error
+
+	// ExportToOCI pushes the image as an OCI image to registryRef, so
+	// the same "golden" image can be consumed by container-native
+	// (bootc/CoreOS-style) hosts. Backends that can't support this
+	// return a descriptive error rather than silently doing nothing.
+	ExportToOCI(registryRef string) error
 }
@@ -0,0 +1,83 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+// AWSConfig holds the configuration needed to talk to AWS and to control
+// the instance/image we build.
+type AWSConfig struct {
+	Region string
+
+	ImageID      string
+	InstanceType string
+
+	SubnetID        string
+	SecurityGroupID string
+
+	SSHKeyName   string
+	SSHPublicKey string
+
+	// Name is recorded as the Name tag on resources we create.
+	Name string
+	// BuildID identifies this build; recorded as the BuildID tag, and
+	// used as the value of the role tag (tagRoleKey). If BuildID is
+	// empty, Name is used as the role tag value instead.
+	BuildID string
+	// ClusterName, if set, tags created resources with
+	// kubernetes.io/cluster/<ClusterName>=owned, so they are
+	// discoverable/garbage-collectable the same way kops-managed
+	// resources are.
+	ClusterName string
+
+	// DiscoveryTags are the tag key=value pairs used to find an existing
+	// instance/subnet/security group for this builder. If empty, we fall
+	// back to requiring just the tagRoleKey tag to be present.
+	DiscoveryTags map[string]string
+
+	// AssumeRoleARN, if set, causes NewAWSCloudFromConfig to assume this
+	// IAM role via STS before talking to EC2, instead of using static or
+	// instance-profile credentials directly.
+	AssumeRoleARN string
+	// ExternalID is passed to sts:AssumeRole when a third party requires
+	// it as a confused-deputy mitigation. Only used with AssumeRoleARN.
+	ExternalID string
+	// SessionName is the role session name used for sts:AssumeRole; if
+	// empty the AWS SDK default is used. Only used with AssumeRoleARN.
+	SessionName string
+
+	// RootVolumeSizeGB overrides the root volume size (in GB) of the
+	// source image; 0 means use the image's default.
+	RootVolumeSizeGB int64
+	// RootVolumeType is the EBS volume type for the root volume, e.g.
+	// "gp3" or "io2"; empty means use the AWS default.
+	RootVolumeType string
+	// RootVolumeIOPS is the provisioned IOPS for the root volume; only
+	// meaningful for io1/io2/gp3 volume types.
+	RootVolumeIOPS int64
+
+	// Encrypted, if true, requests an encrypted root volume for the build
+	// instance and an encrypted copy when replicating the resulting AMI.
+	Encrypted bool
+	// KMSKeyID is the customer-managed KMS key used to encrypt the root
+	// volume and the AMI in its home region. Empty means use the AWS
+	// managed default key.
+	KMSKeyID string
+	// ReplicationKMSKeyIDs maps a destination region to the KMS key that
+	// should be used to re-encrypt the AMI copied into that region. A
+	// region with no entry falls back to that region's AWS managed
+	// default key.
+	ReplicationKMSKeyIDs map[string]string
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+)
+
+// ExportToS3 exports the AMI as a VM disk image (VMDK/RAW/VHD) into an S3
+// bucket/prefix, the way osbuild-composer's AWS uploader does, so the same
+// image can be booted outside EC2.
+func (i *AWSImage) ExportToS3(bucket, prefix, format string) error {
+	if format == "" {
+		format = "VMDK"
+	}
+
+	request := &ec2.ExportImageInput{
+		ImageId:         aws.String(i.imageID),
+		DiskImageFormat: aws.String(format),
+		S3ExportLocation: &ec2.ExportTaskS3LocationRequest{
+			S3Bucket: aws.String(bucket),
+			S3Prefix: aws.String(prefix),
+		},
+	}
+
+	glog.V(2).Infof("AWS ExportImage ImageId=%q Bucket=%q Prefix=%q Format=%q", i.imageID, bucket, prefix, format)
+	response, err := i.ec2.ExportImage(request)
+	if err != nil {
+		return fmt.Errorf("error making AWS ExportImage call: %v", err)
+	}
+
+	taskID := aws.StringValue(response.ExportImageTaskId)
+	if err := i.waitExportImageTask(taskID); err != nil {
+		return fmt.Errorf("error waiting for image %q to export to s3://%s/%s: %v", i.imageID, bucket, prefix, err)
+	}
+
+	return nil
+}
+
+// waitExportImageTask polls DescribeExportImageTasks until the task
+// completes or enters a terminal failure state.
+func (i *AWSImage) waitExportImageTask(taskID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultImageAvailableTimeout)
+	defer cancel()
+
+	delay := 10 * time.Second
+	const maxDelay = 60 * time.Second
+
+	for {
+		request := &ec2.DescribeExportImageTasksInput{
+			ExportImageTaskIds: aws.StringSlice([]string{taskID}),
+		}
+
+		glog.V(2).Infof("AWS DescribeExportImageTasks TaskId=%q", taskID)
+		var response *ec2.DescribeExportImageTasksOutput
+		err := retryOnThrottle(ctx, func() error {
+			var err error
+			response, err = i.ec2.DescribeExportImageTasks(request)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error making AWS DescribeExportImageTasks call: %v", err)
+		}
+		if len(response.ExportImageTasks) == 0 {
+			return fmt.Errorf("export image task not found %q", taskID)
+		}
+
+		task := response.ExportImageTasks[0]
+		status := aws.StringValue(task.Status)
+		glog.V(2).Infof("export image task %q status %q", taskID, status)
+
+		switch status {
+		case "completed":
+			return nil
+		case "deleted", "deleting":
+			return fmt.Errorf("export image task %q entered terminal state %q: %s", taskID, status, aws.StringValue(task.StatusMessage))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for export image task %q (last status %q)", taskID, status)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
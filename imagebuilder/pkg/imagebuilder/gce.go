@@ -0,0 +1,568 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/oauth2/google"
+
+	"github.com/golang/glog"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// labelRoleKey tags the resources we create, so we can find them again.
+// GCE label keys can't contain dots or slashes, so this is not the same
+// literal string as AWS's tagRoleKey, though it serves the same purpose.
+const labelRoleKey = "k8s-io-role-imagebuilder"
+
+// GCEConfig holds the configuration for talking to Google Compute Engine,
+// the GCE analog of AWSConfig.
+type GCEConfig struct {
+	Project string
+	Zone    string
+
+	MachineType string
+	SourceImage string
+
+	Network    string
+	Subnetwork string
+
+	SSHPublicKey string
+
+	// ImageFamily, if set, is recorded on the resulting image so that
+	// callers can always boot "the latest" image in the family.
+	ImageFamily string
+
+	// ReplicationTargets lists the GCE project IDs the built image should
+	// be copied into, e.g. to share it from a build project into a
+	// separate "publishing" project. Unlike AWS regions, GCE images are
+	// global within a project, so there is no separate storage-location
+	// dimension to replicate across.
+	ReplicationTargets []string
+}
+
+// GCEInstance manages a GCE instance, used for building an image
+type GCEInstance struct {
+	cloud        *GCECloud
+	instanceName string
+	instance     *compute.Instance
+}
+
+var _ Instance = &GCEInstance{}
+
+// Address returns the instance's external IP, once it has one
+func (i *GCEInstance) Address() (string, error) {
+	return i.WaitExternalIP()
+}
+
+// Finalize snapshots the instance's boot disk into a new image named name,
+// then deletes the instance.
+func (i *GCEInstance) Finalize(name string) (Image, error) {
+	if len(i.instance.Disks) == 0 {
+		return nil, fmt.Errorf("instance %q has no boot disk to snapshot", i.instanceName)
+	}
+	sourceDisk := i.instance.Disks[0].Source
+
+	request := &compute.Image{
+		Name:       name,
+		Family:     i.cloud.config.ImageFamily,
+		SourceDisk: sourceDisk,
+	}
+
+	glog.V(2).Infof("GCE Images.Insert Project=%q Name=%q SourceDisk=%q", i.cloud.config.Project, name, sourceDisk)
+	op, err := i.cloud.compute.Images.Insert(i.cloud.config.Project, request).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error making GCE Images.Insert call: %v", err)
+	}
+	if err := waitGlobalOperation(i.cloud.compute, i.cloud.config.Project, op.Name); err != nil {
+		return nil, fmt.Errorf("error waiting for image %q to be created: %v", name, err)
+	}
+
+	image, err := findGCEImage(i.cloud.compute, i.cloud.config.Project, name)
+	if err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Deleting instance %q", i.instanceName)
+	if err := i.cloud.deleteInstance(i.instanceName); err != nil {
+		return nil, fmt.Errorf("error deleting instance %q after snapshot: %v", i.instanceName, err)
+	}
+
+	return &GCEImage{
+		compute:            i.cloud.compute,
+		project:            i.cloud.config.Project,
+		image:              image,
+		replicationTargets: i.cloud.config.ReplicationTargets,
+	}, nil
+}
+
+// DialSSH establishes an SSH client connection to the instance
+func (i *GCEInstance) DialSSH(config *ssh.ClientConfig) (*ssh.Client, error) {
+	externalIP, err := i.WaitExternalIP()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		// TODO: Timeout, check error code
+		sshClient, err := ssh.Dial("tcp", externalIP+":22", config)
+		if err != nil {
+			glog.Warningf("error connecting to SSH on server %q: %v", externalIP, err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		return sshClient, nil
+	}
+}
+
+// WaitExternalIP waits for the instance to get an external IP, returning it
+func (i *GCEInstance) WaitExternalIP() (string, error) {
+	// TODO: Timeout
+	for {
+		instance, err := i.cloud.describeInstance(i.instanceName)
+		if err != nil {
+			return "", err
+		}
+		externalIP := findExternalIP(instance)
+		if externalIP != "" {
+			glog.Infof("Instance external IP is %q", externalIP)
+			return externalIP, nil
+		}
+		glog.V(2).Infof("Sleeping before requerying instance for external IP: %q", i.instanceName)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func findExternalIP(instance *compute.Instance) string {
+	for _, ni := range instance.NetworkInterfaces {
+		for _, ac := range ni.AccessConfigs {
+			if ac.NatIP != "" {
+				return ac.NatIP
+			}
+		}
+	}
+	return ""
+}
+
+// GCECloud is a helper type for talking to a GCE project
+type GCECloud struct {
+	config *GCEConfig
+
+	compute *compute.Service
+}
+
+var _ Cloud = &GCECloud{}
+
+func NewGCECloud(computeService *compute.Service, config *GCEConfig) *GCECloud {
+	return &GCECloud{
+		compute: computeService,
+		config:  config,
+	}
+}
+
+// NewGCECloudFromConfig builds a GCECloud, resolving credentials itself via
+// Application Default Credentials rather than requiring the caller to build
+// a *compute.Service. This is the GCE analog of NewAWSCloudFromConfig.
+func NewGCECloudFromConfig(config *GCEConfig) (*GCECloud, error) {
+	ctx := context.Background()
+	client, err := google.DefaultClient(ctx, compute.ComputeScope)
+	if err != nil {
+		return nil, fmt.Errorf("error building GCE credentials: %v", err)
+	}
+
+	computeService, err := compute.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("error building GCE compute service: %v", err)
+	}
+
+	return NewGCECloud(computeService, config), nil
+}
+
+func (c *GCECloud) GetExtraEnv() (map[string]string, error) {
+	// The GCE client libraries pick up credentials from the environment
+	// (GOOGLE_APPLICATION_CREDENTIALS) automatically, so there's nothing
+	// we need to export here.
+	return nil, nil
+}
+
+func (c *GCECloud) describeInstance(instanceName string) (*compute.Instance, error) {
+	glog.V(2).Infof("GCE Instances.Get Project=%q Zone=%q Name=%q", c.config.Project, c.config.Zone, instanceName)
+	instance, err := c.compute.Instances.Get(c.config.Project, c.config.Zone, instanceName).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error making GCE Instances.Get call: %v", err)
+	}
+	return instance, nil
+}
+
+// deleteInstance deletes the specified instance, waiting for the operation to complete
+func (c *GCECloud) deleteInstance(instanceName string) error {
+	glog.V(2).Infof("GCE Instances.Delete Project=%q Zone=%q Name=%q", c.config.Project, c.config.Zone, instanceName)
+	op, err := c.compute.Instances.Delete(c.config.Project, c.config.Zone, instanceName).Do()
+	if err != nil {
+		return fmt.Errorf("error making GCE Instances.Delete call: %v", err)
+	}
+	return c.waitZoneOperation(op.Name)
+}
+
+// GetInstance returns the GCE instance matching our label, or nil if not found
+func (c *GCECloud) GetInstance() (Instance, error) {
+	filter := fmt.Sprintf("labels.%s eq .*", labelRoleKey)
+
+	glog.V(2).Infof("GCE Instances.List Filter=%q", filter)
+	response, err := c.compute.Instances.List(c.config.Project, c.config.Zone).Filter(filter).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error making GCE Instances.List call: %v", err)
+	}
+
+	for _, instance := range response.Items {
+		glog.Infof("Found existing instance: %q", instance.Name)
+		return &GCEInstance{
+			cloud:        c,
+			instance:     instance,
+			instanceName: instance.Name,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// CreateInstance creates an instance for building an image, booting from SourceImage
+func (c *GCECloud) CreateInstance(provisioner Provisioner) (Instance, error) {
+	if c.config.SourceImage == "" {
+		return nil, fmt.Errorf("SourceImage must be specified")
+	}
+	if c.config.MachineType == "" {
+		return nil, fmt.Errorf("MachineType must be specified")
+	}
+	if c.config.Zone == "" {
+		return nil, fmt.Errorf("Zone must be specified")
+	}
+
+	needsSSH := provisioner == nil || provisioner.NeedsSSH()
+
+	networkInterface := &compute.NetworkInterface{
+		Network:    c.config.Network,
+		Subnetwork: c.config.Subnetwork,
+	}
+	if needsSSH {
+		networkInterface.AccessConfigs = []*compute.AccessConfig{
+			{Type: "ONE_TO_ONE_NAT", Name: "External NAT"},
+		}
+	}
+
+	name := "imagebuilder-" + randomInstanceSuffix()
+
+	instance := &compute.Instance{
+		Name:        name,
+		MachineType: machineTypeURL(c.config.Project, c.config.Zone, c.config.MachineType),
+		Labels:      map[string]string{labelRoleKey: "true"},
+		Disks: []*compute.AttachedDisk{
+			{
+				Boot:       true,
+				AutoDelete: true,
+				InitializeParams: &compute.AttachedDiskInitializeParams{
+					SourceImage: c.config.SourceImage,
+				},
+			},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{networkInterface},
+		Metadata:          &compute.Metadata{},
+	}
+
+	if needsSSH {
+		publicKey, err := ReadFile(c.config.SSHPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		instance.Metadata.Items = append(instance.Metadata.Items, &compute.MetadataItems{
+			Key: "ssh-keys", Value: strPtr(sshKeysMetadata(publicKey)),
+		})
+	}
+
+	if provisioner != nil {
+		userData, err := provisioner.UserData()
+		if err != nil {
+			return nil, fmt.Errorf("error building user-data: %v", err)
+		}
+		if len(userData) != 0 {
+			instance.Metadata.Items = append(instance.Metadata.Items, &compute.MetadataItems{
+				Key:   "user-data",
+				Value: strPtr(string(userData)),
+			})
+		}
+	}
+
+	glog.V(2).Infof("GCE Instances.Insert Project=%q Zone=%q Name=%q", c.config.Project, c.config.Zone, name)
+	op, err := c.compute.Instances.Insert(c.config.Project, c.config.Zone, instance).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error making GCE Instances.Insert call: %v", err)
+	}
+	if err := c.waitZoneOperation(op.Name); err != nil {
+		return nil, fmt.Errorf("error waiting for instance %q to be created: %v", name, err)
+	}
+
+	created, err := c.describeInstance(name)
+	if err != nil {
+		return nil, err
+	}
+
+	gceInstance := &GCEInstance{
+		cloud:        c,
+		instance:     created,
+		instanceName: name,
+	}
+
+	if provisioner != nil {
+		if err := provisioner.Provision(gceInstance); err != nil {
+			return nil, fmt.Errorf("error provisioning instance %q: %v", name, err)
+		}
+	}
+
+	return gceInstance, nil
+}
+
+func sshKeysMetadata(publicKey string) string {
+	return "imagebuilder:" + publicKey
+}
+
+func machineTypeURL(project, zone, machineType string) string {
+	return fmt.Sprintf("zones/%s/machineTypes/%s", zone, machineType)
+}
+
+// FindImage finds a registered image, matching by name
+func (c *GCECloud) FindImage(imageName string) (Image, error) {
+	image, err := findGCEImage(c.compute, c.config.Project, imageName)
+	if err != nil {
+		return nil, err
+	}
+	if image == nil {
+		return nil, nil
+	}
+
+	return &GCEImage{
+		compute:            c.compute,
+		project:            c.config.Project,
+		image:              image,
+		replicationTargets: c.config.ReplicationTargets,
+	}, nil
+}
+
+func findGCEImage(client *compute.Service, project, imageName string) (*compute.Image, error) {
+	glog.V(2).Infof("GCE Images.Get Project=%q Name=%q", project, imageName)
+	image, err := client.Images.Get(project, imageName).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error making GCE Images.Get call: %v", err)
+	}
+	return image, nil
+}
+
+// GCEImage represents a custom image in GCE
+type GCEImage struct {
+	compute *compute.Service
+	project string
+	image   *compute.Image
+
+	// replicationTargets lists the projects this image should be copied
+	// into when ReplicateImage is called, taken from GCEConfig.
+	replicationTargets []string
+}
+
+// ID returns the GCE identifier for the image
+func (i *GCEImage) ID() string {
+	return i.image.Name
+}
+
+// String returns a string representation of the image
+func (i *GCEImage) String() string {
+	return "GCEImage[name=" + i.image.Name + "]"
+}
+
+// EnsurePublic makes the image accessible to any authenticated Google account.
+// It does not change the image's family, which is set when the image is
+// created (see Finalize).
+func (i *GCEImage) EnsurePublic() error {
+	if i.image.Family == "" && i.image.Name != "" {
+		// Best effort; the family is normally set at creation time.
+		glog.V(2).Infof("GCE image %q has no family set", i.image.Name)
+	}
+
+	glog.V(2).Infof("GCE Images.GetIamPolicy Project=%q Name=%q", i.project, i.image.Name)
+	policy, err := i.compute.Images.GetIamPolicy(i.project, i.image.Name).Do()
+	if err != nil {
+		return fmt.Errorf("error getting IAM policy for image %q: %v", i.image.Name, err)
+	}
+
+	found := false
+	for _, binding := range policy.Bindings {
+		if binding.Role != "roles/compute.imageUser" {
+			continue
+		}
+		found = true
+		if !containsString(binding.Members, "allAuthenticatedUsers") {
+			binding.Members = append(binding.Members, "allAuthenticatedUsers")
+		}
+	}
+	if !found {
+		policy.Bindings = append(policy.Bindings, &compute.Binding{
+			Role:    "roles/compute.imageUser",
+			Members: []string{"allAuthenticatedUsers"},
+		})
+	}
+
+	glog.V(2).Infof("GCE Images.SetIamPolicy Project=%q Name=%q", i.project, i.image.Name)
+	_, err = i.compute.Images.SetIamPolicy(i.project, i.image.Name, &compute.GlobalSetPolicyRequest{Policy: policy}).Do()
+	if err != nil {
+		return fmt.Errorf("error making image %q public: %v", i.image.Name, err)
+	}
+
+	return nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplicateImage copies the image into the configured target projects
+func (i *GCEImage) ReplicateImage(makePublic bool) (map[string]Image, error) {
+	imagesByTarget := make(map[string]Image)
+	imagesByTarget[i.project] = i
+
+	for _, target := range i.replicationTargets {
+		if target == i.project || imagesByTarget[target] != nil {
+			continue
+		}
+
+		copied, err := i.ReplicateImageTo(target)
+		if err != nil {
+			return nil, fmt.Errorf("error replicating image to %q: %v", target, err)
+		}
+		imagesByTarget[target] = copied
+	}
+
+	if makePublic {
+		for target, image := range imagesByTarget {
+			if err := image.EnsurePublic(); err != nil {
+				return nil, fmt.Errorf("error making image public for target %q: %v", target, err)
+			}
+		}
+	}
+
+	return imagesByTarget, nil
+}
+
+// ExportToS3 is not supported for GCE images.
+func (i *GCEImage) ExportToS3(bucket, prefix, format string) error {
+	return fmt.Errorf("ExportToS3 is not supported for GCE images")
+}
+
+// ExportToOCI is not supported for GCE images.
+func (i *GCEImage) ExportToOCI(registryRef string) error {
+	return fmt.Errorf("ExportToOCI is not supported for GCE images")
+}
+
+// ReplicateImageTo copies this image into another GCE project, e.g. so that
+// a separate "publishing" project can share it with allAuthenticatedUsers.
+func (i *GCEImage) ReplicateImageTo(targetProject string) (Image, error) {
+	sourceURL := fmt.Sprintf("projects/%s/global/images/%s", i.project, i.image.Name)
+
+	request := &compute.Image{
+		Name:        i.image.Name,
+		Family:      i.image.Family,
+		SourceImage: sourceURL,
+	}
+
+	glog.V(2).Infof("GCE Images.Insert Project=%q Name=%q SourceImage=%q", targetProject, i.image.Name, sourceURL)
+	op, err := i.compute.Images.Insert(targetProject, request).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error copying image to project %q: %v", targetProject, err)
+	}
+	if err := waitGlobalOperation(i.compute, targetProject, op.Name); err != nil {
+		return nil, fmt.Errorf("error waiting for image copy to project %q: %v", targetProject, err)
+	}
+
+	copied, err := findGCEImage(i.compute, targetProject, i.image.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCEImage{
+		compute: i.compute,
+		project: targetProject,
+		image:   copied,
+	}, nil
+}
+
+func (c *GCECloud) waitZoneOperation(name string) error {
+	for {
+		op, err := c.compute.ZoneOperations.Get(c.config.Project, c.config.Zone, name).Do()
+		if err != nil {
+			return fmt.Errorf("error making GCE ZoneOperations.Get call: %v", err)
+		}
+		if op.Status == "DONE" {
+			if op.Error != nil && len(op.Error.Errors) != 0 {
+				return fmt.Errorf("GCE operation %q failed: %v", name, op.Error.Errors[0].Message)
+			}
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func waitGlobalOperation(client *compute.Service, project, name string) error {
+	for {
+		op, err := client.GlobalOperations.Get(project, name).Do()
+		if err != nil {
+			return fmt.Errorf("error making GCE GlobalOperations.Get call: %v", err)
+		}
+		if op.Status == "DONE" {
+			if op.Error != nil && len(op.Error.Errors) != 0 {
+				return fmt.Errorf("GCE operation %q failed: %v", name, op.Error.Errors[0].Message)
+			}
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func isNotFound(err error) bool {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return apiErr.Code == 404
+	}
+	return false
+}
+
+func randomInstanceSuffix() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
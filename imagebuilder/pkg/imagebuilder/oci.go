@@ -0,0 +1,158 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ebs"
+	"github.com/golang/glog"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// ExportToOCI converts the AMI's root snapshot into a single-layer OCI
+// image and pushes it to registryRef, so the same build can be consumed
+// by both EC2 and bootc/CoreOS-style container-native hosts.
+func (i *AWSImage) ExportToOCI(registryRef string) error {
+	rootSnapshotID, err := i.rootSnapshotID()
+	if err != nil {
+		return err
+	}
+
+	rawDiskFile, err := ioutil.TempFile("", "imagebuilder-oci-layer")
+	if err != nil {
+		return fmt.Errorf("error creating temp file for OCI export: %v", err)
+	}
+	defer os.Remove(rawDiskFile.Name())
+	defer rawDiskFile.Close()
+
+	ebsClient := ebs.New(session.New(), &aws.Config{Region: aws.String(i.region)})
+	if err := downloadSnapshotToFile(ebsClient, rootSnapshotID, rawDiskFile.Name()); err != nil {
+		return fmt.Errorf("error downloading snapshot %q: %v", rootSnapshotID, err)
+	}
+
+	layer, err := tarball.LayerFromFile(rawDiskFile.Name())
+	if err != nil {
+		return fmt.Errorf("error building OCI layer from snapshot %q: %v", rootSnapshotID, err)
+	}
+
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		return fmt.Errorf("error building OCI image: %v", err)
+	}
+
+	glog.V(2).Infof("pushing OCI image for AMI %q to %q", i.imageID, registryRef)
+	if err := crane.Push(image, registryRef); err != nil {
+		return fmt.Errorf("error pushing OCI image to %q: %v", registryRef, err)
+	}
+
+	return nil
+}
+
+// rootSnapshotID returns the EBS snapshot ID backing the image's root
+// device, which is what we export as the single OCI layer.
+func (i *AWSImage) rootSnapshotID() (string, error) {
+	if i.image == nil {
+		return "", fmt.Errorf("image %q has no block device information loaded", i.imageID)
+	}
+
+	rootDeviceName := aws.StringValue(i.image.RootDeviceName)
+	for _, mapping := range i.image.BlockDeviceMappings {
+		if aws.StringValue(mapping.DeviceName) != rootDeviceName {
+			continue
+		}
+		if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil {
+			return "", fmt.Errorf("root device %q for image %q has no EBS snapshot", rootDeviceName, i.imageID)
+		}
+		return aws.StringValue(mapping.Ebs.SnapshotId), nil
+	}
+
+	return "", fmt.Errorf("could not find root device %q for image %q", rootDeviceName, i.imageID)
+}
+
+// downloadSnapshotToFile reconstructs a raw disk image from an EBS
+// snapshot's blocks using the EBS direct APIs, writing it to destPath.
+func downloadSnapshotToFile(client *ebs.EBS, snapshotID string, destPath string) error {
+	out, err := os.OpenFile(destPath, os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening %q for writing: %v", destPath, err)
+	}
+	defer out.Close()
+
+	request := &ebs.ListSnapshotBlocksInput{
+		SnapshotId: aws.String(snapshotID),
+	}
+
+	var pageErr error
+	sizedDestFile := false
+
+	glog.V(2).Infof("EBS ListSnapshotBlocks SnapshotId=%q", snapshotID)
+	err = client.ListSnapshotBlocksPages(request, func(page *ebs.ListSnapshotBlocksOutput, lastPage bool) bool {
+		blockSize := aws.Int64Value(page.BlockSize)
+
+		// The snapshot's sparse/unallocated trailing blocks are never
+		// returned by ListSnapshotBlocks, so pre-size the destination file
+		// to the full volume size or the raw image ends up truncated.
+		if !sizedDestFile {
+			volumeSizeBytes := aws.Int64Value(page.VolumeSize) * 1024 * 1024 * 1024
+			if err := out.Truncate(volumeSizeBytes); err != nil {
+				pageErr = fmt.Errorf("error sizing %q to volume size for snapshot %q: %v", destPath, snapshotID, err)
+				return false
+			}
+			sizedDestFile = true
+		}
+
+		for _, block := range page.Blocks {
+			blockIndex := aws.Int64Value(block.BlockIndex)
+
+			blockRequest := &ebs.GetSnapshotBlockInput{
+				SnapshotId: aws.String(snapshotID),
+				BlockIndex: block.BlockIndex,
+				BlockToken: block.BlockToken,
+			}
+
+			blockResponse, err := client.GetSnapshotBlock(blockRequest)
+			if err != nil {
+				pageErr = fmt.Errorf("error fetching block %d of snapshot %q: %v", blockIndex, snapshotID, err)
+				return false
+			}
+
+			_, seekErr := out.Seek(blockIndex*blockSize, 0)
+			if seekErr == nil {
+				_, seekErr = out.ReadFrom(blockResponse.BlockData)
+			}
+			blockResponse.BlockData.Close()
+			if seekErr != nil {
+				pageErr = fmt.Errorf("error writing block %d of snapshot %q: %v", blockIndex, snapshotID, seekErr)
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error making EBS ListSnapshotBlocks call: %v", err)
+	}
+	return pageErr
+}
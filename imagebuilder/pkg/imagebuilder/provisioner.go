@@ -0,0 +1,202 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/golang/glog"
+)
+
+// Provisioner completes the setup of a build instance, between it being
+// created and it being snapshotted into an image. CreateInstance hands the
+// newly created Instance to the configured Provisioner.
+type Provisioner interface {
+	// UserData returns the cloud-init/user-data payload to attach to the
+	// instance at creation time, or nil if this provisioner doesn't need
+	// any (e.g. because it configures the instance over SSH after boot).
+	UserData() ([]byte, error)
+
+	// Provision completes setup of instance once it has been created.
+	// Provisioners that do everything via UserData can make this a no-op.
+	Provision(instance Instance) error
+
+	// NeedsSSH reports whether this provisioner requires SSH access to the
+	// instance, so CreateInstance knows whether it can skip provisioning a
+	// key pair and a public IP (e.g. for private-subnet/air-gapped builds).
+	NeedsSSH() bool
+}
+
+// ShellProvisioner runs a fixed list of shell commands over SSH, which was
+// the only supported behavior before the Provisioner interface existed.
+type ShellProvisioner struct {
+	// Commands are run over SSH, in order, once the instance is reachable.
+	Commands []string
+
+	// SSHConfig is used to dial the instance.
+	SSHConfig *ssh.ClientConfig
+}
+
+var _ Provisioner = &ShellProvisioner{}
+
+// UserData implements Provisioner
+func (p *ShellProvisioner) UserData() ([]byte, error) {
+	return nil, nil
+}
+
+// NeedsSSH implements Provisioner
+func (p *ShellProvisioner) NeedsSSH() bool {
+	return true
+}
+
+// Provision implements Provisioner
+func (p *ShellProvisioner) Provision(instance Instance) error {
+	client, err := instance.DialSSH(p.SSHConfig)
+	if err != nil {
+		return fmt.Errorf("error dialing SSH for provisioning: %v", err)
+	}
+	defer client.Close()
+
+	for _, command := range p.Commands {
+		session, err := client.NewSession()
+		if err != nil {
+			return fmt.Errorf("error creating SSH session: %v", err)
+		}
+
+		glog.V(2).Infof("running provisioning command: %s", command)
+		output, err := session.CombinedOutput(command)
+		session.Close()
+		if err != nil {
+			return fmt.Errorf("error running command %q: %v (output: %s)", command, err, output)
+		}
+	}
+
+	return nil
+}
+
+// CloudInitProvisioner passes a cloud-init document as the instance's
+// user-data, so the instance configures itself at boot without needing an
+// SSH key or a public IP. This is what makes private-subnet / air-gapped
+// builds possible.
+type CloudInitProvisioner struct {
+	// Script is the cloud-init user-data document (e.g. a #cloud-config
+	// document or a "#!/bin/bash" script) to attach to the instance.
+	Script string
+}
+
+var _ Provisioner = &CloudInitProvisioner{}
+
+// UserData implements Provisioner
+func (p *CloudInitProvisioner) UserData() ([]byte, error) {
+	return []byte(p.Script), nil
+}
+
+// Provision implements Provisioner
+func (p *CloudInitProvisioner) Provision(instance Instance) error {
+	// Everything happens via cloud-init at boot; there is nothing left to
+	// do here, and in particular nothing that requires SSH access.
+	return nil
+}
+
+// NeedsSSH implements Provisioner
+func (p *CloudInitProvisioner) NeedsSSH() bool {
+	return false
+}
+
+// AnsibleProvisioner runs a local ansible-playbook invocation against the
+// instance's address, using a generated single-host inventory.
+type AnsibleProvisioner struct {
+	Playbook string
+
+	// User is the SSH user ansible should connect as.
+	User string
+	// PrivateKeyFile is passed to ansible-playbook as the private key
+	// matching the instance's authorized SSH key.
+	PrivateKeyFile string
+
+	ExtraVars map[string]string
+}
+
+var _ Provisioner = &AnsibleProvisioner{}
+
+// UserData implements Provisioner
+func (p *AnsibleProvisioner) UserData() ([]byte, error) {
+	return nil, nil
+}
+
+// NeedsSSH implements Provisioner
+func (p *AnsibleProvisioner) NeedsSSH() bool {
+	return true
+}
+
+// Provision implements Provisioner
+func (p *AnsibleProvisioner) Provision(instance Instance) error {
+	addr, err := instance.Address()
+	if err != nil {
+		return fmt.Errorf("error determining instance address for ansible: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultInstanceRunningTimeout)
+	defer cancel()
+	if err := waitTCPPortOpen(ctx, addr+":22"); err != nil {
+		return fmt.Errorf("error waiting for SSH to become reachable for ansible: %v", err)
+	}
+
+	inventory, err := ioutil.TempFile("", "imagebuilder-inventory")
+	if err != nil {
+		return fmt.Errorf("error creating ansible inventory file: %v", err)
+	}
+	defer os.Remove(inventory.Name())
+
+	line := addr
+	if p.User != "" {
+		line += " ansible_user=" + p.User
+	}
+	if p.PrivateKeyFile != "" {
+		line += " ansible_ssh_private_key_file=" + p.PrivateKeyFile
+	}
+	if _, err := inventory.WriteString(line + "\n"); err != nil {
+		inventory.Close()
+		return fmt.Errorf("error writing ansible inventory file: %v", err)
+	}
+	if err := inventory.Close(); err != nil {
+		return fmt.Errorf("error writing ansible inventory file: %v", err)
+	}
+
+	args := []string{"-i", inventory.Name()}
+	for k, v := range p.ExtraVars {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
+	}
+	args = append(args, p.Playbook)
+
+	glog.V(2).Infof("running ansible-playbook %s", strings.Join(args, " "))
+	cmd := exec.Command("ansible-playbook", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running ansible-playbook: %v (output: %s)", err, output)
+	}
+	glog.V(2).Infof("ansible-playbook output: %s", output)
+
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/glog"
+)
+
+const (
+	// minThrottleDelay and maxThrottleDelay bound the backoff we use when
+	// an AWS API call is throttled (RequestLimitExceeded and friends).
+	minThrottleDelay = 1 * time.Second
+	maxThrottleDelay = 60 * time.Second
+
+	// maxSSHDialDelay bounds the total time spent waiting for an
+	// instance's SSH port to start accepting connections.
+	maxSSHDialDelay = 2 * time.Minute
+)
+
+// isThrottlingError returns true if err is an AWS rate-limiting error that
+// is worth retrying, rather than a fatal request error.
+func isThrottlingError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch awsErr.Code() {
+	case "RequestLimitExceeded", "Throttling", "ThrottlingException", "TooManyRequestsException":
+		return true
+	}
+	return false
+}
+
+// retryOnThrottle calls f, retrying with exponential backoff between
+// minThrottleDelay and maxThrottleDelay while f returns an AWS throttling
+// error.
+func retryOnThrottle(ctx context.Context, f func() error) error {
+	delay := minThrottleDelay
+	for {
+		err := f()
+		if err == nil || !isThrottlingError(err) {
+			return err
+		}
+
+		glog.V(2).Infof("AWS request throttled, retrying in %v", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxThrottleDelay {
+			delay = maxThrottleDelay
+		}
+	}
+}
+
+// waitTCPPortOpen polls addr until a TCP connection succeeds, backing off
+// exponentially up to maxSSHDialDelay total.
+func waitTCPPortOpen(ctx context.Context, addr string) error {
+	deadline := time.Now().Add(maxSSHDialDelay)
+	delay := 1 * time.Second
+
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %q to accept TCP connections: %v", addr, err)
+		}
+
+		glog.V(2).Infof("waiting for %q to accept TCP connections: %v", addr, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
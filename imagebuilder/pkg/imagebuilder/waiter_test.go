@@ -0,0 +1,136 @@
+/*
+Copyright 2016 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagebuilder
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestIsThrottlingError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("boom"), false},
+		{"RequestLimitExceeded", awserr.New("RequestLimitExceeded", "slow down", nil), true},
+		{"Throttling", awserr.New("Throttling", "slow down", nil), true},
+		{"ThrottlingException", awserr.New("ThrottlingException", "slow down", nil), true},
+		{"TooManyRequestsException", awserr.New("TooManyRequestsException", "slow down", nil), true},
+		{"unrelated AWS error", awserr.New("InvalidParameterValue", "bad value", nil), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isThrottlingError(c.err); got != c.want {
+				t.Errorf("isThrottlingError(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetryOnThrottleReturnsImmediatelyOnSuccess(t *testing.T) {
+	calls := 0
+	err := retryOnThrottle(context.Background(), func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("retryOnThrottle returned %v, want nil", err)
+	}
+	if calls != 1 {
+		t.Errorf("f was called %d times, want 1", calls)
+	}
+}
+
+func TestRetryOnThrottleReturnsNonThrottlingErrorImmediately(t *testing.T) {
+	wantErr := errors.New("not a throttling error")
+	calls := 0
+	err := retryOnThrottle(context.Background(), func() error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("retryOnThrottle returned %v, want %v", err, wantErr)
+	}
+	if calls != 1 {
+		t.Errorf("f was called %d times, want 1", calls)
+	}
+}
+
+func TestRetryOnThrottleStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := retryOnThrottle(ctx, func() error {
+		return awserr.New("Throttling", "slow down", nil)
+	})
+	elapsed := time.Since(start)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("retryOnThrottle returned %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("retryOnThrottle took %v to notice context cancellation, want well under maxThrottleDelay", elapsed)
+	}
+}
+
+func TestWaitTCPPortOpenSucceedsOnOpenPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting test listener: %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := waitTCPPortOpen(ctx, listener.Addr().String()); err != nil {
+		t.Fatalf("waitTCPPortOpen returned %v for an open port", err)
+	}
+}
+
+func TestWaitTCPPortOpenStopsWhenContextDone(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting test listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close() // closed port: connections should fail
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = waitTCPPortOpen(ctx, addr)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("waitTCPPortOpen returned nil for a closed port")
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("waitTCPPortOpen took %v to give up, want well under maxSSHDialDelay", elapsed)
+	}
+}